@@ -0,0 +1,246 @@
+/*
+ * This file is part of arduino-cli.
+ *
+ * arduino-cli is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2017-2018 ARDUINO AG (http://www.arduino.cc/)
+ */
+
+package packagemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SetDownloadCache sets the directory used to store downloaded tool and platform archives,
+// keyed by their declared SHA-256 checksum rather than by URL. Passing the same checksum
+// again, even from a different URL (a mirror, a rotated signed URL, ...), is served from
+// disk instead of hitting the network. The directory is created if it doesn't exist yet.
+func (pm *PackageManager) SetDownloadCache(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating download cache directory: %s", err)
+	}
+	pm.downloadCacheDir = dir
+	return nil
+}
+
+// cachePath returns the path an archive with the given "SHA-256:<hex>" checksum would be
+// stored at in the download cache.
+func (pm *PackageManager) cachePath(checksum string) (string, error) {
+	if !strings.HasPrefix(checksum, "SHA-256:") {
+		return "", fmt.Errorf("unsupported checksum format: %s", checksum)
+	}
+	sum := strings.TrimPrefix(checksum, "SHA-256:")
+	return filepath.Join(pm.downloadCacheDir, sum), nil
+}
+
+// DownloadToolRelease downloads the archive described by dl into the download cache,
+// verifying its SHA-256 and size as it streams, and returns the path to the verified,
+// cached archive.
+//
+// If dl.Checksum is known and an archive matching it is already present in the cache, it
+// is returned immediately without touching the network (and its modification time is
+// bumped, so PruneCache's LRU eviction sees it as recently used). If dl.Checksum is empty
+// -- package_index.json doesn't guarantee one, and a DownloadURLResolver may legitimately
+// not provide one either -- the cache can't be consulted up front, but the archive is still
+// downloaded, its SHA-256 computed on the fly, and the result cached keyed by that computed
+// checksum so later requests that do know the checksum get a cache hit.
+//
+// A partially written or corrupted download never lands at the final cache path: the
+// archive is streamed to a temporary file in the same directory and atomically renamed into
+// place only once it has been fully verified (when a checksum was supplied).
+func (pm *PackageManager) DownloadToolRelease(dl *ResolvedDownload) (string, error) {
+	if pm.downloadCacheDir == "" {
+		return "", fmt.Errorf("no download cache directory configured, call SetDownloadCache first")
+	}
+
+	if dl.Checksum != "" {
+		if dest, err := pm.cachePath(dl.Checksum); err == nil {
+			if info, statErr := os.Stat(dest); statErr == nil && (dl.Size <= 0 || info.Size() == dl.Size) {
+				now := time.Now()
+				os.Chtimes(dest, now, now)
+				return dest, nil
+			}
+		}
+	}
+
+	pm.emit(func(h EventHandler) { h.OnDownloadStart(dl.URL, dl.Size) })
+
+	resp, err := http.Get(dl.URL)
+	if err != nil {
+		pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, err) })
+		return "", fmt.Errorf("downloading %s: %s", dl.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("downloading %s: unexpected HTTP status %s", dl.URL, resp.Status)
+		pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, err) })
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(pm.downloadCacheDir, ".download-*")
+	if err != nil {
+		pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, err) })
+		return "", fmt.Errorf("creating temporary download file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	counter := &countingReader{r: io.TeeReader(resp.Body, hasher)}
+	lastReport := int64(0)
+	for {
+		buf := make([]byte, 64*1024)
+		n, readErr := counter.Read(buf)
+		if n > 0 {
+			if _, writeErr := tmp.Write(buf[:n]); writeErr != nil {
+				pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, writeErr) })
+				return "", fmt.Errorf("writing downloaded data: %s", writeErr)
+			}
+			if counter.n-lastReport > 256*1024 {
+				lastReport = counter.n
+				pm.emit(func(h EventHandler) { h.OnDownloadProgress(dl.URL, counter.n) })
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, readErr) })
+			return "", fmt.Errorf("downloading %s: %s", dl.URL, readErr)
+		}
+	}
+
+	if dl.Size > 0 && counter.n != dl.Size {
+		err := fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", dl.URL, dl.Size, counter.n)
+		pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, err) })
+		return "", err
+	}
+
+	got := "SHA-256:" + hex.EncodeToString(hasher.Sum(nil))
+	if dl.Checksum != "" && got != dl.Checksum {
+		err := fmt.Errorf("checksum mismatch for %s: expected %s, got %s", dl.URL, dl.Checksum, got)
+		pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, err) })
+		return "", err
+	}
+
+	// Cache keyed by the checksum we actually computed: when dl.Checksum was already known
+	// this is the same value (we just verified it matches), and when it wasn't, this is
+	// what makes the archive content-addressable for next time.
+	dest, err := pm.cachePath(got)
+	if err != nil {
+		pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, err) })
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, err) })
+		return "", fmt.Errorf("closing downloaded file: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, err) })
+		return "", fmt.Errorf("moving downloaded file into cache: %s", err)
+	}
+
+	pm.emit(func(h EventHandler) { h.OnDownloadFinish(dl.URL, nil) })
+	return dest, nil
+}
+
+// countingReader wraps an io.Reader and tracks how many bytes have been read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// PruneCache removes cached archives that are either older than maxAge or, if the cache
+// exceeds maxBytes in total size, the least recently used ones until it no longer does.
+// "Recently used" is tracked via each file's modification time: it's set once when the
+// archive is first cached, and DownloadToolRelease bumps it on every later cache hit, so it
+// doubles as a last-access time. Pass maxAge <= 0 or maxBytes <= 0 to skip that bound.
+func (pm *PackageManager) PruneCache(maxAge time.Duration, maxBytes int64) error {
+	if pm.downloadCacheDir == "" {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(pm.downloadCacheDir)
+	if err != nil {
+		return fmt.Errorf("reading download cache directory: %s", err)
+	}
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	now := time.Now()
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(pm.downloadCacheDir, entry.Name())
+		if maxAge > 0 && now.Sub(entry.ModTime()) > maxAge {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("pruning %s: %s", path, err)
+			}
+			continue
+		}
+		files = append(files, cached{path: path, size: entry.Size(), modTime: entry.ModTime()})
+		total += entry.Size()
+	}
+
+	if maxBytes > 0 && total > maxBytes {
+		sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+		for _, f := range files {
+			if total <= maxBytes {
+				break
+			}
+			if err := os.Remove(f.path); err != nil {
+				return fmt.Errorf("pruning %s: %s", f.path, err)
+			}
+			total -= f.size
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+ * This file is part of arduino-cli.
+ *
+ * arduino-cli is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2017-2018 ARDUINO AG (http://www.arduino.cc/)
+ */
+
+package packagemanager
+
+import (
+	"github.com/bcmi-labs/arduino-cli/cores"
+)
+
+// DownloadURLResolver allows callers to override the URL (and the associated checksum and
+// size metadata) used when downloading a tool or platform release archive. It is typically
+// backed by a short-lived signed URL pointing at a private mirror, and, when it returns
+// ok == true, takes precedence over whatever is declared in package_index.json.
+//
+// packager, tool and version identify the release being resolved (tool is left empty when
+// resolving a platform release); host and arch identify the OS/architecture flavor being
+// fetched, using the same naming as package_index.json (e.g. "i686-pc-linux-gnu").
+type DownloadURLResolver func(packager, tool, version, host, arch string) (url string, checksum string, size int64, ok bool)
+
+// ResolvedDownload carries the URL and integrity metadata that should be used to download
+// an archive, regardless of whether it came from a registered DownloadURLResolver or from
+// package_index.json.
+type ResolvedDownload struct {
+	URL      string
+	Checksum string
+	Size     int64
+}
+
+// ResolvedToolInstall pairs a required ToolRelease with the ResolvedDownload that should be
+// used to fetch its archive, so that callers of FindToolsRequiredForBoard and
+// FindToolDependency never have to remember to consult ResolveToolDownload themselves.
+type ResolvedToolInstall struct {
+	Release  *cores.ToolRelease
+	Download *ResolvedDownload
+}
+
+// RegisterDownloadURLResolver installs a DownloadURLResolver that is consulted before
+// falling back to the URL declared in package_index.json for every subsequent tool or
+// platform download. Registering a resolver replaces any previously registered one.
+func (pm *PackageManager) RegisterDownloadURLResolver(resolver DownloadURLResolver) {
+	pm.downloadURLResolver = resolver
+}
+
+// ResolveToolDownload determines the URL, checksum and size that should be used to
+// download the given ToolRelease for host/arch, consulting the registered
+// DownloadURLResolver first and falling back to the release's own metadata from
+// package_index.json when no resolver is registered or it declines to override this
+// release.
+func (pm *PackageManager) ResolveToolDownload(release *cores.ToolRelease, host, arch string) *ResolvedDownload {
+	packager := release.Tool.Package.Name
+	tool := release.Tool.Name
+
+	if pm.downloadURLResolver != nil {
+		if url, checksum, size, ok := pm.downloadURLResolver(packager, tool, release.Version, host, arch); ok {
+			return &ResolvedDownload{URL: url, Checksum: checksum, Size: size}
+		}
+	}
+
+	resource := release.GetDownloadResourceFor(host, arch)
+	if resource == nil {
+		return nil
+	}
+	return &ResolvedDownload{URL: resource.URL, Checksum: resource.Checksum, Size: resource.Size}
+}
+
+// ResolvePlatformDownload determines the URL, checksum and size that should be used to
+// download the given PlatformRelease for host/arch, consulting the registered
+// DownloadURLResolver first (with tool left empty, as documented on DownloadURLResolver)
+// and falling back to the release's own metadata from package_index.json when no resolver
+// is registered or it declines to override this release.
+func (pm *PackageManager) ResolvePlatformDownload(release *cores.PlatformRelease, host, arch string) *ResolvedDownload {
+	packager := release.Platform.Package.Name
+
+	if pm.downloadURLResolver != nil {
+		if url, checksum, size, ok := pm.downloadURLResolver(packager, "", release.Version, host, arch); ok {
+			return &ResolvedDownload{URL: url, Checksum: checksum, Size: size}
+		}
+	}
+
+	resource := release.GetDownloadResourceFor(host, arch)
+	if resource == nil {
+		return nil
+	}
+	return &ResolvedDownload{URL: resource.URL, Checksum: resource.Checksum, Size: resource.Size}
+}
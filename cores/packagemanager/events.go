@@ -0,0 +1,139 @@
+/*
+ * This file is part of arduino-cli.
+ *
+ * arduino-cli is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2017-2018 ARDUINO AG (http://www.arduino.cc/)
+ */
+
+package packagemanager
+
+import (
+	"github.com/bcmi-labs/arduino-cli/cores"
+)
+
+// EventHandler is the set of hooks a subscriber can implement to observe what a
+// PackageManager is doing. All methods are optional in spirit but must be implemented to
+// satisfy the interface; embed EventHandlerAdapter to only override the ones you care
+// about.
+//
+// Every method is invoked synchronously by the goroutine driving the PackageManager
+// operation, so handlers that want to do expensive work (writing to a terminal, a log
+// file...) should hand off to their own goroutine instead of blocking the caller.
+type EventHandler interface {
+	// OnIndexLoadStart/OnIndexLoadFinish bracket the loading of a single package_index.json.
+	OnIndexLoadStart(url string)
+	OnIndexLoadFinish(url string, err error)
+
+	// OnPackageDiscovered/OnPlatformDiscovered/OnToolDiscovered fire as a loaded index is
+	// merged into the in-memory package database.
+	OnPackageDiscovered(packager string)
+	OnPlatformDiscovered(packager, platform, version string)
+	OnToolDiscovered(packager, tool, version string)
+
+	// OnDependencyResolutionStep fires once per platform or tool release chosen while
+	// resolving a ResolutionPlan (see Resolve), with the reason it was chosen. For a platform,
+	// tool carries the platform's architecture.
+	OnDependencyResolutionStep(tool, version, reason string)
+
+	// OnDownloadStart/OnDownloadProgress/OnDownloadFinish bracket the download of a single
+	// artifact (a tool or platform archive), as opposed to the old global download handler.
+	OnDownloadStart(artifact string, totalSize int64)
+	OnDownloadProgress(artifact string, downloaded int64)
+	OnDownloadFinish(artifact string, err error)
+
+	// OnInstallStart/OnInstallFinish and OnUninstallStart/OnUninstallFinish are the hooks for
+	// installing/removing a downloaded release from disk. PackageManager has no install or
+	// uninstall step of its own, so it never fires them.
+	OnInstallStart(release *cores.ToolRelease)
+	OnInstallFinish(release *cores.ToolRelease, err error)
+	OnUninstallStart(release *cores.ToolRelease)
+	OnUninstallFinish(release *cores.ToolRelease, err error)
+
+	// OnVerbose is the hook for free-form log lines. PackageManager never fires it.
+	OnVerbose(line string)
+}
+
+// EventHandlerAdapter is a no-op EventHandler. Embed it anonymously to implement only the
+// callbacks you're interested in, e.g.:
+//
+//	type progressPrinter struct {
+//		packagemanager.EventHandlerAdapter
+//	}
+//
+//	func (p *progressPrinter) OnDownloadProgress(artifact string, downloaded int64) { ... }
+type EventHandlerAdapter struct{}
+
+func (EventHandlerAdapter) OnIndexLoadStart(url string)                             {}
+func (EventHandlerAdapter) OnIndexLoadFinish(url string, err error)                 {}
+func (EventHandlerAdapter) OnPackageDiscovered(packager string)                     {}
+func (EventHandlerAdapter) OnPlatformDiscovered(packager, platform, version string) {}
+func (EventHandlerAdapter) OnToolDiscovered(packager, tool, version string)         {}
+func (EventHandlerAdapter) OnDependencyResolutionStep(tool, version, reason string) {}
+func (EventHandlerAdapter) OnDownloadStart(artifact string, totalSize int64)        {}
+func (EventHandlerAdapter) OnDownloadProgress(artifact string, downloaded int64)    {}
+func (EventHandlerAdapter) OnDownloadFinish(artifact string, err error)             {}
+func (EventHandlerAdapter) OnInstallStart(release *cores.ToolRelease)               {}
+func (EventHandlerAdapter) OnInstallFinish(release *cores.ToolRelease, err error)   {}
+func (EventHandlerAdapter) OnUninstallStart(release *cores.ToolRelease)             {}
+func (EventHandlerAdapter) OnUninstallFinish(release *cores.ToolRelease, err error) {}
+func (EventHandlerAdapter) OnVerbose(line string)                                   {}
+
+// Subscribe registers handler to receive every event fired by pm from now on, and returns
+// an unsubscribe function that removes it again. Multiple handlers may be subscribed at
+// once; events are fanned out to all of them. Subscribe/unsubscribe and event emission are
+// all safe to call concurrently, and handlers may be unsubscribed in any order.
+func (pm *PackageManager) Subscribe(handler EventHandler) (unsubscribe func()) {
+	pm.handlersMutex.Lock()
+	defer pm.handlersMutex.Unlock()
+
+	pm.nextHandlerID++
+	id := pm.nextHandlerID
+	if pm.handlers == nil {
+		pm.handlers = map[int]EventHandler{}
+	}
+	pm.handlers[id] = handler
+
+	return func() {
+		pm.handlersMutex.Lock()
+		defer pm.handlersMutex.Unlock()
+		delete(pm.handlers, id)
+	}
+}
+
+// emit fans fn out to every currently subscribed EventHandler. It takes a snapshot of the
+// handler list under lock, then invokes the handlers outside the lock so a handler that
+// calls back into Subscribe/unsubscribe cannot deadlock.
+func (pm *PackageManager) emit(fn func(EventHandler)) {
+	pm.handlersMutex.RLock()
+	handlers := make([]EventHandler, 0, len(pm.handlers))
+	for _, handler := range pm.handlers {
+		handlers = append(handlers, handler)
+	}
+	pm.handlersMutex.RUnlock()
+
+	for _, handler := range handlers {
+		fn(handler)
+	}
+}
@@ -34,8 +34,8 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 
-	"github.com/bcmi-labs/arduino-cli/common/releases"
 	"github.com/bcmi-labs/arduino-cli/configs"
 	"github.com/bcmi-labs/arduino-cli/cores"
 	"github.com/bcmi-labs/arduino-cli/cores/packageindex"
@@ -50,17 +50,19 @@ import (
 type PackageManager struct {
 	packages *cores.Packages
 
-	// TODO: This might be a list in the future, but would it be of any help?
-	eventHandler EventHandler
-}
+	// downloadURLResolver, when set, is consulted before falling back to the URL
+	// declared in package_index.json for a given tool/platform release.
+	downloadURLResolver DownloadURLResolver
+
+	// downloadCacheDir, when set via SetDownloadCache, is where downloaded tool/platform
+	// archives are stored, keyed by their SHA-256 checksum.
+	downloadCacheDir string
 
-// EventHandler defines the events that are generated by the PackageManager
-// Subscribing to such events allows, for instance, to print out logs of what is happening
-// (say you use them for a CLI...)
-type EventHandler interface {
-	// FIXME: This is temporary, for prototyping (an handler should not return an handler; besides, this leakes
-	// the usage of releases...)
-	OnDownloadingSomething() releases.ParallelDownloadProgressHandler
+	// handlers is keyed by a monotonically increasing ID (nextHandlerID) rather than a
+	// slice position, so unsubscribing one handler can never shift another's identity.
+	handlersMutex sync.RWMutex
+	handlers      map[int]EventHandler
+	nextHandlerID int
 }
 
 // NewPackageManager returns a new instance of the PackageManager
@@ -118,20 +120,51 @@ func (pm *PackageManager) FindBoardsWithID(id string) []*cores.Board {
 	return res
 }
 
-// FindBoardWithFQBN returns the board identified by the fqbn, or an error
-func (pm *PackageManager) FindBoardWithFQBN(fqbn string) (*cores.Board, error) {
+// BoardConfig is the result of parsing an FQBN against a board's boards.txt: the board
+// itself, plus the resolved value (optionID) of every menu declared for it that could be
+// resolved. A menu present in boards.txt but not specified in the FQBN is filled in with a
+// default value when it has only one option; when it has several and none was requested,
+// its true default can't be determined (see resolveBoardConfig) and it is left out of
+// Options rather than guessed at.
+type BoardConfig struct {
+	Board   *cores.Board
+	Options map[string]string // menuID -> optionID
+}
+
+// FindBoardWithFQBN returns the board identified by the fqbn, along with the resolved
+// configuration options carried by its trailing "key=value,key=value" menu-options
+// segment, or an error.
+//
+// fqbn must have the form "package:arch:boardID" or "package:arch:boardID:menuID=optionID,...".
+// Every menuID in the options segment must match a "menu.<menuID>" declared for the board
+// in boards.txt, and its optionID must be one of the options declared under that menu; a
+// menu left unspecified is filled in with its only option when it has just one, or left out
+// of the returned BoardConfig.Options when it has several and no true default can be
+// determined (see resolveBoardConfig) -- finding the board never fails just because one of
+// its menus can't be defaulted.
+func (pm *PackageManager) FindBoardWithFQBN(fqbn string) (*cores.Board, *BoardConfig, error) {
 	// Split fqbn
-	fqbnParts := strings.Split(fqbn, ":")
-	if len(fqbnParts) < 3 || len(fqbnParts) > 4 {
-		return nil, errors.New("incorrect format for fqbn")
+	fqbnParts := strings.SplitN(fqbn, ":", 4)
+	if len(fqbnParts) < 3 {
+		return nil, nil, errors.New("incorrect format for fqbn")
 	}
 	packageName := fqbnParts[0]
 	platformArch := fqbnParts[1]
 	boardID := fqbnParts[2]
 
+	requestedOptions := map[string]string{}
+	if len(fqbnParts) == 4 {
+		for _, pair := range strings.Split(fqbnParts[3], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, nil, fmt.Errorf("invalid fqbn configuration option: %s", pair)
+			}
+			requestedOptions[kv[0]] = kv[1]
+		}
+	}
+
 	// Search for the board
 	for _, targetPackage := range pm.packages.Packages {
-		fmt.Println(targetPackage.Name, packageName)
 		if targetPackage.Name != packageName {
 			continue
 		}
@@ -142,46 +175,162 @@ func (pm *PackageManager) FindBoardWithFQBN(fqbn string) (*cores.Board, error) {
 
 			platform := targetPlatform.GetInstalled()
 			if platform == nil {
-				return nil, errors.New("platform not installed")
+				return nil, nil, errors.New("platform not installed")
 			}
 			for _, board := range platform.Boards {
-				if board.BoardId == boardID {
-					return board, nil
+				if board.BoardId != boardID {
+					continue
+				}
+				config, err := resolveBoardConfig(board, requestedOptions)
+				if err != nil {
+					return nil, nil, err
 				}
+				return board, config, nil
 			}
 		}
 	}
-	return nil, errors.New("board not found")
+	return nil, nil, errors.New("board not found")
 }
 
-// FIXME add an handler to be invoked on each verbose operation, in order to let commands display results through the formatter
-// as for the progress bars during download
-func (pm *PackageManager) RegisterEventHandler(eventHandler EventHandler) {
-	if pm.eventHandler != nil {
-		panic("Don't try to register another event handler to the PackageManager yet!")
+// resolveBoardConfig validates requestedOptions (menuID -> optionID, as parsed from an
+// FQBN) against the "menu.*" entries declared for board in boards.txt, and fills in a
+// default optionID for every menu the caller left unspecified where that default is
+// unambiguous.
+//
+// CAVEAT: by the time this runs, board.Properties is already a flattened map, so the order
+// menu options were declared in boards.txt -- which is what actually determines boards.txt's
+// default ("first declared option wins") -- is not recoverable here. Guessing at a default
+// (e.g. the lexicographically-smallest optionID) can silently pick the wrong one, so a menu
+// left unspecified is only defaulted when it has a single option; a menu with more than one
+// known option and no requested value is left out of the returned Options entirely rather
+// than guessed at or failing the whole lookup -- finding the board is this function's job
+// even when one of its menus can't be defaulted. Fixing this properly requires threading
+// declaration order through from whatever parses boards.txt, which this package does not
+// have access to.
+func resolveBoardConfig(board *cores.Board, requestedOptions map[string]string) (*BoardConfig, error) {
+	menus := map[string]map[string]bool{} // menuID -> set of known optionIDs
+	for key := range board.Properties {
+		if !strings.HasPrefix(key, "menu.") {
+			continue
+		}
+		parts := strings.SplitN(key, ".", 4)
+		if len(parts) < 3 {
+			continue
+		}
+		menuID, optionID := parts[1], parts[2]
+		if menus[menuID] == nil {
+			menus[menuID] = map[string]bool{}
+		}
+		menus[menuID][optionID] = true
+	}
+
+	resolved := map[string]string{}
+	for menuID, optionID := range requestedOptions {
+		options, exists := menus[menuID]
+		if !exists {
+			return nil, fmt.Errorf("invalid menu '%s' for board %s", menuID, board.BoardId)
+		}
+		if !options[optionID] {
+			return nil, fmt.Errorf("invalid option '%s' for menu '%s' on board %s", optionID, menuID, board.BoardId)
+		}
+		resolved[menuID] = optionID
+	}
+
+	for menuID, options := range menus {
+		if _, ok := resolved[menuID]; ok {
+			continue
+		}
+		if len(options) != 1 {
+			// Ambiguous: boards.txt's declaration order would decide the real default, and
+			// that order isn't recoverable here (see the CAVEAT above). Leave it unresolved
+			// rather than guess, but don't fail the whole lookup over it.
+			continue
+		}
+		for optionID := range options {
+			resolved[menuID] = optionID
+		}
 	}
 
-	pm.eventHandler = eventHandler
+	return &BoardConfig{Board: board, Options: resolved}, nil
 }
 
-// GetEventHandlers returns a slice of the registered EventHandlers
-func (pm *PackageManager) GetEventHandlers() []*EventHandler {
-	return append([]*EventHandler{}, &pm.eventHandler)
+// packageIndexSnapshot records every packager, platform release and tool release pm already
+// knows about, so two snapshots taken around a MergeIntoPackages call can be diffed to find
+// out what that call actually added, instead of re-announcing everything pm has ever seen.
+type packageIndexSnapshot struct {
+	packagers map[string]bool
+	platforms map[string]bool // "packager|architecture|version"
+	tools     map[string]bool // "packager|tool|version"
+}
+
+// snapshotPackages captures the current contents of pm.packages for use with
+// packageIndexSnapshot.
+func (pm *PackageManager) snapshotPackages() packageIndexSnapshot {
+	snap := packageIndexSnapshot{
+		packagers: map[string]bool{},
+		platforms: map[string]bool{},
+		tools:     map[string]bool{},
+	}
+	for packager, pkg := range pm.packages.Packages {
+		snap.packagers[packager] = true
+		for _, platform := range pkg.Platforms {
+			for _, rel := range platform.Releases {
+				snap.platforms[packager+"|"+platform.Architecture+"|"+rel.Version] = true
+			}
+		}
+		for _, tool := range pkg.Tools {
+			for _, rel := range tool.Releases {
+				snap.tools[packager+"|"+tool.Name+"|"+rel.Version] = true
+			}
+		}
+	}
+	return snap
 }
 
 // LoadPackageIndex loads a package index by looking up the local cached file from the specified URL
 func (pm *PackageManager) LoadPackageIndex(URL *url.URL) error {
+	pm.emit(func(h EventHandler) { h.OnIndexLoadStart(URL.String()) })
+
 	indexPath, err := configs.IndexPathFromURL(URL).Get()
 	if err != nil {
-		return fmt.Errorf("retrieving json index path for %s: %s", URL, err)
+		err = fmt.Errorf("retrieving json index path for %s: %s", URL, err)
+		pm.emit(func(h EventHandler) { h.OnIndexLoadFinish(URL.String(), err) })
+		return err
 	}
 
 	index, err := packageindex.LoadIndex(indexPath)
 	if err != nil {
-		return fmt.Errorf("loading json index file %s: %s", indexPath, err)
+		err = fmt.Errorf("loading json index file %s: %s", indexPath, err)
+		pm.emit(func(h EventHandler) { h.OnIndexLoadFinish(URL.String(), err) })
+		return err
 	}
 
+	before := pm.snapshotPackages()
 	index.MergeIntoPackages(pm.packages)
+
+	for packager, pkg := range pm.packages.Packages {
+		if !before.packagers[packager] {
+			pm.emit(func(h EventHandler) { h.OnPackageDiscovered(packager) })
+		}
+		for _, platform := range pkg.Platforms {
+			for _, rel := range platform.Releases {
+				if before.platforms[packager+"|"+platform.Architecture+"|"+rel.Version] {
+					continue
+				}
+				pm.emit(func(h EventHandler) { h.OnPlatformDiscovered(packager, platform.Architecture, rel.Version) })
+			}
+		}
+		for _, tool := range pkg.Tools {
+			for _, rel := range tool.Releases {
+				if before.tools[packager+"|"+tool.Name+"|"+rel.Version] {
+					continue
+				}
+				pm.emit(func(h EventHandler) { h.OnToolDiscovered(packager, tool.Name, rel.Version) })
+			}
+		}
+	}
+
+	pm.emit(func(h EventHandler) { h.OnIndexLoadFinish(URL.String(), nil) })
 	return nil
 }
 
@@ -265,6 +414,19 @@ func (ta *toolActions) Release(version string) *toolReleaseActions {
 	if ta.forwardError != nil {
 		return &toolReleaseActions{forwardError: ta.forwardError}
 	}
+
+	if version == "latest" {
+		// Note: a DownloadURLResolver takes the version as an input (it can only override
+		// the URL used to fetch a specific release), so it has no say in which version
+		// "latest" resolves to. It still gets a chance to override the URL once the chosen
+		// release is actually downloaded, via ResolveToolDownload/FindToolsRequiredForBoard.
+		release := latestRelease(ta.tool)
+		if release == nil {
+			return &toolReleaseActions{forwardError: fmt.Errorf("no releases found for tool %s", ta.tool.String())}
+		}
+		return &toolReleaseActions{release: release}
+	}
+
 	release := ta.tool.GetRelease(version)
 	if release == nil {
 		return &toolReleaseActions{forwardError: fmt.Errorf("release %s not found for tool %s", version, ta.tool.String())}
@@ -302,13 +464,18 @@ func (pm *PackageManager) GetAllInstalledToolsReleases() []*cores.ToolRelease {
 	return tools
 }
 
-func (pm *PackageManager) FindToolsRequiredForBoard(board *cores.Board) ([]*cores.ToolRelease, error) {
+// FindToolsRequiredForBoard returns every ToolRelease needed to build/upload for board,
+// paired with the URL/checksum/size that should be used to download its archive for
+// host/arch -- consulting any registered DownloadURLResolver first and falling back to the
+// release's own package_index.json metadata, so callers never need to resolve the download
+// URL themselves.
+func (pm *PackageManager) FindToolsRequiredForBoard(board *cores.Board, host, arch string) ([]*ResolvedToolInstall, error) {
 	// core := board.Properties["build.core"]
 
 	platform := board.PlatformRelease
 
-	// maps "PACKAGER:TOOL" => ToolRelease
-	foundTools := map[string]*cores.ToolRelease{}
+	// maps "PACKAGER:TOOL" => ResolvedToolInstall
+	foundTools := map[string]*ResolvedToolInstall{}
 
 	// a Platform may not specify required tools (because it's a platform that comes from a
 	// sketchbook/hardware folder without a package_index.json) then add all available tools
@@ -316,31 +483,41 @@ func (pm *PackageManager) FindToolsRequiredForBoard(board *cores.Board) ([]*core
 		for _, tool := range targetPackage.Tools {
 			rel := tool.GetLatestInstalled()
 			if rel != nil {
-				foundTools[rel.Tool.String()] = rel
+				foundTools[rel.Tool.String()] = &ResolvedToolInstall{
+					Release:  rel,
+					Download: pm.ResolveToolDownload(rel, host, arch),
+				}
 			}
 		}
 	}
 
 	// replace the default tools above with the specific required by the current platform
 	for _, toolDep := range platform.Dependencies {
-		tool := pm.FindToolDependency(toolDep)
+		tool := pm.FindToolDependency(toolDep, host, arch)
 		if tool == nil {
 			return nil, fmt.Errorf("tool release not found: %s", toolDep)
 		}
-		foundTools[tool.Tool.String()] = tool
+		foundTools[tool.Release.Tool.String()] = tool
 	}
 
-	requiredTools := []*cores.ToolRelease{}
-	for _, toolRel := range foundTools {
-		requiredTools = append(requiredTools, toolRel)
+	requiredTools := make([]*ResolvedToolInstall, 0, len(foundTools))
+	for _, tool := range foundTools {
+		requiredTools = append(requiredTools, tool)
 	}
 	return requiredTools, nil
 }
 
-func (pm *PackageManager) FindToolDependency(dep *cores.ToolDependency) *cores.ToolRelease {
+// FindToolDependency returns the ToolRelease satisfying dep paired with the ResolvedDownload
+// that should be used to fetch its archive for host/arch -- consulting any registered
+// DownloadURLResolver first and falling back to the release's own package_index.json
+// metadata, same as FindToolsRequiredForBoard -- or nil if no release satisfies dep.
+func (pm *PackageManager) FindToolDependency(dep *cores.ToolDependency, host, arch string) *ResolvedToolInstall {
 	toolRelease, err := pm.Package(dep.ToolPackager).Tool(dep.ToolName).Release(dep.ToolVersion).Get()
 	if err != nil {
 		return nil
 	}
-	return toolRelease
+	return &ResolvedToolInstall{
+		Release:  toolRelease,
+		Download: pm.ResolveToolDownload(toolRelease, host, arch),
+	}
 }
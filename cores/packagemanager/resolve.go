@@ -0,0 +1,458 @@
+/*
+ * This file is part of arduino-cli.
+ *
+ * arduino-cli is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2017-2018 ARDUINO AG (http://www.arduino.cc/)
+ */
+
+package packagemanager
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/bcmi-labs/arduino-cli/cores"
+)
+
+// InstallRequest describes a platform or a standalone tool the caller wants installed.
+// Exactly one of Platform or Tool must be set. Constraint restricts which version is
+// acceptable: "" means any, an exact version ("1.8.3") pins it, and "<op>version" with
+// op one of ">=", "<=", ">", "<" selects a range ("==" and "=" pin exactly).
+type InstallRequest struct {
+	Packager   string
+	Platform   string
+	Tool       string
+	Constraint string
+}
+
+// ResolutionAction is a single step of a ResolutionPlan.
+type ResolutionAction struct {
+	// Remove is true if this step uninstalls ToolRelease/PlatformRelease rather than
+	// installing it.
+	Remove bool
+
+	ToolRelease     *cores.ToolRelease
+	PlatformRelease *cores.PlatformRelease
+
+	// Download is where this action's archive should come from (consulting any registered
+	// DownloadURLResolver), for the host/arch Resolve ran on. Nil for Remove actions.
+	Download *ResolvedDownload
+
+	// Reason is a short, human-readable explanation of why this release was chosen
+	// (e.g. "required to satisfy >=1.0.0", "already installed, kept to minimize churn").
+	Reason string
+}
+
+// ResolutionPlan is the ordered result of PackageManager.Resolve: the set of installs and
+// removals required to satisfy the requested InstallRequests, together with the reasoning
+// behind each choice.
+type ResolutionPlan struct {
+	Actions []*ResolutionAction
+}
+
+// String renders the plan as a human-readable, one-action-per-line explanation suitable
+// for a CLI dry-run preview.
+func (p *ResolutionPlan) String() string {
+	out := ""
+	for _, action := range p.Actions {
+		verb := "install"
+		name := ""
+		switch {
+		case action.ToolRelease != nil:
+			name = action.ToolRelease.Tool.String() + "@" + action.ToolRelease.Version
+		case action.PlatformRelease != nil:
+			name = action.PlatformRelease.Platform.String() + "@" + action.PlatformRelease.Version
+		}
+		if action.Remove {
+			verb = "remove"
+		}
+		out += fmt.Sprintf("%s %s (%s)\n", verb, name, action.Reason)
+	}
+	return out
+}
+
+// satisfiesConstraint reports whether version satisfies constraint. An empty constraint is
+// always satisfied; a constraint with no recognized operator prefix (">=", "<=", "==",
+// ">", "<", "=") is treated as an exact pin.
+func satisfiesConstraint(version, constraint string) bool {
+	constraint = strings.TrimSpace(constraint)
+	if constraint == "" {
+		return true
+	}
+
+	for _, op := range []string{">=", "<=", "==", ">", "<", "="} {
+		if !strings.HasPrefix(constraint, op) {
+			continue
+		}
+		want := strings.TrimSpace(strings.TrimPrefix(constraint, op))
+		cmp := compareVersions(version, want)
+		switch op {
+		case ">=":
+			return cmp >= 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		case "<":
+			return cmp < 0
+		case "==", "=":
+			return cmp == 0
+		}
+	}
+
+	// No operator: treat the whole constraint as an exact pin.
+	return version == constraint
+}
+
+// satisfiesAllConstraints reports whether version satisfies every constraint in cs (the
+// conjunction of all of them), so that e.g. ">=1.0" together with "==1.2" correctly narrows
+// to 1.2 instead of being treated as two independent, order-dependent checks.
+func satisfiesAllConstraints(version string, cs []string) bool {
+	for _, c := range cs {
+		if !satisfiesConstraint(version, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// namedConstraints joins the non-empty constraints in cs with ", ", for use in a
+// human-readable reason string; it returns "" when none of them are named.
+func namedConstraints(cs []string) string {
+	named := make([]string, 0, len(cs))
+	for _, c := range cs {
+		if c != "" {
+			named = append(named, c)
+		}
+	}
+	return strings.Join(named, ", ")
+}
+
+// resolveToolForConstraints picks the ToolRelease for packager:toolName that satisfies every
+// constraint in constraints (their conjunction, not just the first one seen). Among every
+// release that does, an already-installed one is preferred (the highest-versioned installed
+// match) to minimize churn; otherwise the highest-versioned matching release is chosen to be
+// installed.
+func (pm *PackageManager) resolveToolForConstraints(packager, toolName string, constraints []string) (*cores.ToolRelease, error) {
+	pkg := pm.packages.Packages[packager]
+	if pkg == nil {
+		return nil, fmt.Errorf("package not found: %s", packager)
+	}
+	tool := pkg.Tools[toolName]
+	if tool == nil {
+		return nil, fmt.Errorf("tool not found: %s:%s", packager, toolName)
+	}
+
+	var bestInstalled, best *cores.ToolRelease
+	for _, release := range tool.Releases {
+		if !satisfiesAllConstraints(release.Version, constraints) {
+			continue
+		}
+		if best == nil || compareVersions(release.Version, best.Version) > 0 {
+			best = release
+		}
+		if release.IsInstalled() && (bestInstalled == nil || compareVersions(release.Version, bestInstalled.Version) > 0) {
+			bestInstalled = release
+		}
+	}
+	if bestInstalled != nil {
+		return bestInstalled, nil
+	}
+	if best != nil {
+		return best, nil
+	}
+	return nil, fmt.Errorf("no release of tool %s:%s satisfies constraints %q (conflicting requirements)", packager, toolName, namedConstraints(constraints))
+}
+
+// resolvePlatformForConstraint picks the PlatformRelease for packager:arch that best
+// satisfies constraint, using the same already-installed-first preference as
+// resolveToolForConstraints. An empty constraint keeps the previous "just use whatever is
+// installed" behavior.
+func (pm *PackageManager) resolvePlatformForConstraint(packager, arch, constraint string) (*cores.PlatformRelease, error) {
+	pkg := pm.packages.Packages[packager]
+	if pkg == nil {
+		return nil, fmt.Errorf("package not found: %s", packager)
+	}
+
+	var platform *cores.Platform
+	for _, candidate := range pkg.Platforms {
+		if candidate.Architecture == arch {
+			platform = candidate
+			break
+		}
+	}
+	if platform == nil {
+		return nil, fmt.Errorf("platform not found: %s:%s", packager, arch)
+	}
+
+	if constraint == "" {
+		if installed := platform.GetInstalled(); installed != nil {
+			return installed, nil
+		}
+		return nil, fmt.Errorf("no installable release found for platform %s:%s", packager, arch)
+	}
+
+	var bestInstalled, best *cores.PlatformRelease
+	for _, release := range platform.Releases {
+		if !satisfiesConstraint(release.Version, constraint) {
+			continue
+		}
+		if best == nil || compareVersions(release.Version, best.Version) > 0 {
+			best = release
+		}
+		if release.IsInstalled() && (bestInstalled == nil || compareVersions(release.Version, bestInstalled.Version) > 0) {
+			bestInstalled = release
+		}
+	}
+	if bestInstalled != nil {
+		return bestInstalled, nil
+	}
+	if best != nil {
+		return best, nil
+	}
+	return nil, fmt.Errorf("no release of platform %s:%s satisfies constraint %q", packager, arch, constraint)
+}
+
+// runtimeDownloadHostArch maps the running process's GOOS/GOARCH to the host/arch flavor
+// strings used throughout package_index.json (e.g. "linux"/"amd64" becomes
+// "x86_64-pc-linux-gnu"/"x86_64"), since that is what GetDownloadResourceFor matches against,
+// not Go's own runtime.GOOS/runtime.GOARCH spelling. Callers of FindToolsRequiredForBoard are
+// expected to pass an already-mapped host/arch themselves; Resolve has no caller-supplied
+// value to work with, so it maps the host it's actually running on here.
+func runtimeDownloadHostArch() (host, arch string) {
+	arch, ok := map[string]string{
+		"amd64": "x86_64",
+		"386":   "i686",
+		"arm":   "arm",
+		"arm64": "aarch64",
+	}[runtime.GOARCH]
+	if !ok {
+		arch = runtime.GOARCH
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		host = arch + "-pc-linux-gnu"
+	case "darwin":
+		host = arch + "-apple-darwin"
+	case "windows":
+		host = arch + "-mingw32"
+	default:
+		host = arch + "-" + runtime.GOOS
+	}
+	return host, arch
+}
+
+// supersededPlatformReleases returns every installed release of packager:platformArch other
+// than keep, so Resolve can plan their removal once a different version has been chosen for
+// that platform.
+func (pm *PackageManager) supersededPlatformReleases(packager, platformArch string, keep *cores.PlatformRelease) []*cores.PlatformRelease {
+	pkg := pm.packages.Packages[packager]
+	if pkg == nil {
+		return nil
+	}
+	var superseded []*cores.PlatformRelease
+	for _, candidate := range pkg.Platforms {
+		if candidate.Architecture != platformArch {
+			continue
+		}
+		for _, release := range candidate.Releases {
+			if release != keep && release.IsInstalled() {
+				superseded = append(superseded, release)
+			}
+		}
+	}
+	return superseded
+}
+
+// supersededToolReleases returns every installed release of packager:toolName other than
+// keep that no other installed platform still depends on, so Resolve can plan their removal
+// once a different version has been chosen for that tool -- without proposing to delete a
+// release a platform outside the current Resolve call relies on. removedPlatforms lists the
+// platform releases this same plan is already removing, so their dependencies don't count as
+// "still required" just because IsInstalled() hasn't caught up with the not-yet-executed plan.
+func (pm *PackageManager) supersededToolReleases(packager, toolName string, keep *cores.ToolRelease, removedPlatforms map[*cores.PlatformRelease]bool) []*cores.ToolRelease {
+	pkg := pm.packages.Packages[packager]
+	if pkg == nil {
+		return nil
+	}
+	tool := pkg.Tools[toolName]
+	if tool == nil {
+		return nil
+	}
+	var superseded []*cores.ToolRelease
+	for _, release := range tool.Releases {
+		if release != keep && release.IsInstalled() && !pm.toolReleaseStillRequired(release, removedPlatforms) {
+			superseded = append(superseded, release)
+		}
+	}
+	return superseded
+}
+
+// toolReleaseStillRequired reports whether any installed platform release, anywhere across
+// every loaded package, still depends on release -- so a Resolve call that picks a new
+// version of a shared tool for one target doesn't tear down a version a completely different,
+// untouched installed platform still needs. removedPlatforms excludes platform releases this
+// same plan is already removing: Resolve never mutates install state, so such a release would
+// otherwise still report IsInstalled() and count as a live dependent, under-reporting the
+// very tool removals a platform version switch is meant to produce.
+func (pm *PackageManager) toolReleaseStillRequired(release *cores.ToolRelease, removedPlatforms map[*cores.PlatformRelease]bool) bool {
+	packager := release.Tool.Package.Name
+	toolName := release.Tool.Name
+	for _, pkg := range pm.packages.Packages {
+		for _, platform := range pkg.Platforms {
+			for _, platformRelease := range platform.Releases {
+				if !platformRelease.IsInstalled() || removedPlatforms[platformRelease] {
+					continue
+				}
+				for _, dep := range platformRelease.Dependencies {
+					if dep.ToolPackager == packager && dep.ToolName == toolName && satisfiesConstraint(release.Version, dep.ToolVersion) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Resolve walks the transitive dependency graph of the given targets across every loaded
+// package index, resolves conflicting version constraints on the same tool by intersecting
+// every constraint seen for it (rather than locking in whichever target is visited first),
+// and returns an ordered ResolutionPlan of installs needed to satisfy them -- one action per
+// requested platform plus one per tool pulled in, transitively, by any of them or requested
+// standalone -- plus one Remove action for every other installed release of a resolved
+// platform/tool, so switching versions doesn't leave the superseded one behind. Both platform
+// and tool targets honor Constraint as a version range, not just an exact pin.
+//
+// Already-installed releases that satisfy the constraints are preferred over installing a
+// different version, to minimize churn. The returned plan is a dry-run: Resolve never
+// touches disk, it only decides what would need to happen; each action's Download is
+// resolved for the current host/arch via ResolveToolDownload/ResolvePlatformDownload so
+// callers don't need to do that themselves (Remove actions leave Download nil).
+func (pm *PackageManager) Resolve(targets []*InstallRequest) (*ResolutionPlan, error) {
+	host, arch := runtimeDownloadHostArch()
+
+	// Constraints are only accumulated here, per "PACKAGER:TOOL" key; resolution happens in
+	// a second pass once every target has contributed its requirements, so a tool's final
+	// release is chosen against the conjunction of all constraints, not just the first one.
+	toolConstraints := map[string][]string{}
+	toolOrder := []string{}
+	addToolConstraint := func(packager, toolName, constraint string) {
+		key := packager + ":" + toolName
+		if _, seen := toolConstraints[key]; !seen {
+			toolOrder = append(toolOrder, key)
+		}
+		toolConstraints[key] = append(toolConstraints[key], constraint)
+	}
+
+	plan := &ResolutionPlan{}
+
+	for _, target := range targets {
+		switch {
+		case target.Platform != "":
+			installed, err := pm.resolvePlatformForConstraint(target.Packager, target.Platform, target.Constraint)
+			if err != nil {
+				return nil, err
+			}
+
+			reason := "requested platform"
+			if named := namedConstraints([]string{target.Constraint}); named != "" {
+				reason = fmt.Sprintf("requested platform, constrained to %s", named)
+			}
+			if installed.IsInstalled() {
+				reason = "already installed, kept to minimize churn"
+			}
+			pm.emit(func(h EventHandler) { h.OnDependencyResolutionStep(target.Platform, installed.Version, reason) })
+			plan.Actions = append(plan.Actions, &ResolutionAction{
+				PlatformRelease: installed,
+				Download:        pm.ResolvePlatformDownload(installed, host, arch),
+				Reason:          reason,
+			})
+
+			for _, old := range pm.supersededPlatformReleases(target.Packager, target.Platform, installed) {
+				plan.Actions = append(plan.Actions, &ResolutionAction{
+					Remove:          true,
+					PlatformRelease: old,
+					Reason:          fmt.Sprintf("superseded by %s@%s", target.Platform, installed.Version),
+				})
+			}
+
+			for _, dep := range installed.Dependencies {
+				addToolConstraint(dep.ToolPackager, dep.ToolName, dep.ToolVersion)
+			}
+
+		case target.Tool != "":
+			addToolConstraint(target.Packager, target.Tool, target.Constraint)
+
+		default:
+			return nil, fmt.Errorf("install request for package %q specifies neither a platform nor a tool", target.Packager)
+		}
+	}
+
+	// Platform removals decided above are collected here so toolReleaseStillRequired doesn't
+	// treat a platform this same plan is already removing as a live dependent of its tools.
+	removedPlatforms := map[*cores.PlatformRelease]bool{}
+	for _, action := range plan.Actions {
+		if action.Remove && action.PlatformRelease != nil {
+			removedPlatforms[action.PlatformRelease] = true
+		}
+	}
+
+	for _, key := range toolOrder {
+		packager, toolName, _ := strings.Cut(key, ":")
+		constraints := toolConstraints[key]
+
+		release, err := pm.resolveToolForConstraints(packager, toolName, constraints)
+		if err != nil {
+			return nil, err
+		}
+
+		reason := fmt.Sprintf("required dependency, resolved to version %s", release.Version)
+		if named := namedConstraints(constraints); named != "" {
+			reason = fmt.Sprintf("required to satisfy %s", named)
+		}
+		if release.IsInstalled() {
+			reason = "already installed, kept to minimize churn"
+		}
+		pm.emit(func(h EventHandler) { h.OnDependencyResolutionStep(toolName, release.Version, reason) })
+		plan.Actions = append(plan.Actions, &ResolutionAction{
+			ToolRelease: release,
+			Download:    pm.ResolveToolDownload(release, host, arch),
+			Reason:      reason,
+		})
+
+		for _, old := range pm.supersededToolReleases(packager, toolName, release, removedPlatforms) {
+			plan.Actions = append(plan.Actions, &ResolutionAction{
+				Remove:      true,
+				ToolRelease: old,
+				Reason:      fmt.Sprintf("superseded by %s@%s", toolName, release.Version),
+			})
+		}
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,127 @@
+/*
+ * This file is part of arduino-cli.
+ *
+ * arduino-cli is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2017-2018 ARDUINO AG (http://www.arduino.cc/)
+ */
+
+package packagemanager
+
+import (
+	"testing"
+
+	"github.com/bcmi-labs/arduino-cli/cores"
+)
+
+// newTestPackageManager builds a PackageManager over a single "arduino" package with an
+// "avr" platform (one release, with a tool dependency) and an "avr-gcc" tool (three
+// releases), so Resolve has something non-trivial to chew on.
+func newTestPackageManager() *PackageManager {
+	pkg := &cores.Package{
+		Name:      "arduino",
+		Tools:     map[string]*cores.Tool{},
+		Platforms: map[string]*cores.Platform{},
+	}
+
+	tool := &cores.Tool{Name: "avr-gcc", Package: pkg}
+	for _, v := range []string{"1.0.0", "1.2.0", "2.0.0"} {
+		tool.Releases = append(tool.Releases, &cores.ToolRelease{Tool: tool, Version: v})
+	}
+	pkg.Tools["avr-gcc"] = tool
+
+	platform := &cores.Platform{Architecture: "avr", Package: pkg}
+	platformRelease := &cores.PlatformRelease{
+		Platform: platform,
+		Version:  "1.8.3",
+		Dependencies: []*cores.ToolDependency{
+			{ToolPackager: "arduino", ToolName: "avr-gcc", ToolVersion: ">=1.0.0"},
+		},
+	}
+	platform.Releases = append(platform.Releases, platformRelease)
+	pkg.Platforms["avr"] = platform
+
+	pm := NewPackageManager()
+	pm.packages.Packages[pkg.Name] = pkg
+	return pm
+}
+
+// TestResolvePlatformTarget verifies that requesting a platform produces a
+// ResolutionAction for the platform itself, not just for its tool dependencies.
+func TestResolvePlatformTarget(t *testing.T) {
+	pm := newTestPackageManager()
+
+	plan, err := pm.Resolve([]*InstallRequest{
+		{Packager: "arduino", Platform: "avr", Constraint: "==1.8.3"},
+	})
+	if err != nil {
+		t.Fatalf("Resolve returned an error: %s", err)
+	}
+
+	var sawPlatform bool
+	for _, action := range plan.Actions {
+		if action.PlatformRelease != nil && action.PlatformRelease.Version == "1.8.3" {
+			sawPlatform = true
+		}
+	}
+	if !sawPlatform {
+		t.Fatalf("expected a ResolutionAction for the requested platform release, got: %s", plan)
+	}
+}
+
+// TestResolveIntersectingConstraints verifies that two constraints on the same tool are
+// intersected rather than only the first one being honored, so ">=1.0.0" together with
+// "==1.2.0" correctly resolves to 1.2.0 instead of erroring.
+func TestResolveIntersectingConstraints(t *testing.T) {
+	pm := newTestPackageManager()
+
+	plan, err := pm.Resolve([]*InstallRequest{
+		{Packager: "arduino", Tool: "avr-gcc", Constraint: ">=1.0.0"},
+		{Packager: "arduino", Tool: "avr-gcc", Constraint: "==1.2.0"},
+	})
+	if err != nil {
+		t.Fatalf("Resolve returned an error: %s", err)
+	}
+
+	if len(plan.Actions) != 1 {
+		t.Fatalf("expected exactly one action for the single intersected tool, got %d: %s", len(plan.Actions), plan)
+	}
+	if got := plan.Actions[0].ToolRelease.Version; got != "1.2.0" {
+		t.Fatalf("expected intersected constraints to resolve to 1.2.0, got %s", got)
+	}
+}
+
+// TestResolveConflictingConstraints verifies that constraints with no common match are
+// still reported as an error, rather than silently picking one of them.
+func TestResolveConflictingConstraints(t *testing.T) {
+	pm := newTestPackageManager()
+
+	_, err := pm.Resolve([]*InstallRequest{
+		{Packager: "arduino", Tool: "avr-gcc", Constraint: "==1.0.0"},
+		{Packager: "arduino", Tool: "avr-gcc", Constraint: "==2.0.0"},
+	})
+	if err == nil {
+		t.Fatalf("expected an error for mutually exclusive constraints, got a plan instead")
+	}
+}
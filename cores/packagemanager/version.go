@@ -0,0 +1,85 @@
+/*
+ * This file is part of arduino-cli.
+ *
+ * arduino-cli is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2017-2018 ARDUINO AG (http://www.arduino.cc/)
+ */
+
+package packagemanager
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bcmi-labs/arduino-cli/cores"
+)
+
+// compareVersions compares two dot-separated version strings numerically segment by
+// segment (falling back to a lexicographic compare of a segment when it isn't numeric), and
+// returns -1, 0 or 1 the same way strings.Compare does. A version with fewer segments than
+// the other is considered smaller if the shared prefix is equal (e.g. "1.8" < "1.8.1").
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var as1, bs1 string
+		if i < len(as) {
+			as1 = as[i]
+		}
+		if i < len(bs) {
+			bs1 = bs[i]
+		}
+
+		an, aErr := strconv.Atoi(as1)
+		bn, bErr := strconv.Atoi(bs1)
+		if aErr == nil && bErr == nil {
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+
+		if as1 != bs1 {
+			return strings.Compare(as1, bs1)
+		}
+	}
+	return 0
+}
+
+// latestRelease returns the ToolRelease with the highest semver-like version among all of
+// tool's known Releases, or nil if the tool has none.
+func latestRelease(tool *cores.Tool) *cores.ToolRelease {
+	var latest *cores.ToolRelease
+	for _, release := range tool.Releases {
+		if latest == nil || compareVersions(release.Version, latest.Version) > 0 {
+			latest = release
+		}
+	}
+	return latest
+}